@@ -0,0 +1,71 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies the compression format used to encode a Profile's
+// marshaled bytes, for use with WriteCompressed.
+type Codec int
+
+const (
+	// CodecGzip is the format used by Write, and the default for any
+	// profile.proto payload encountered by Parse/ParseData.
+	CodecGzip Codec = iota
+	// CodecZstd compresses with zstd.
+	CodecZstd
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// isZstd reports whether data begins with the zstd frame magic
+// number.
+func isZstd(data []byte) bool {
+	if len(data) < len(zstdMagic) {
+		return false
+	}
+	for i, b := range zstdMagic {
+		if data[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteCompressed writes the profile as a marshaled protobuf
+// compressed with the given codec.
+func (p *Profile) WriteCompressed(w io.Writer, codec Codec) error {
+	switch codec {
+	case CodecGzip:
+		return p.Write(w)
+	case CodecZstd:
+		p.preEncode()
+		b := marshal(p)
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		defer zw.Close()
+		_, err = zw.Write(b)
+		return err
+	default:
+		return fmt.Errorf("unknown compression codec %d", codec)
+	}
+}
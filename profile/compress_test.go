@@ -0,0 +1,79 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIsZstd(t *testing.T) {
+	if isZstd(append([]byte{0x28, 0xb5, 0x2f, 0xfd}, "anything"...)) != true {
+		t.Error("isZstd(zstd-magic-prefixed data) = false, want true")
+	}
+	if isZstd([]byte{0x1f, 0x8b, 0x08}) {
+		t.Error("isZstd(gzip magic) = true, want false")
+	}
+	if isZstd([]byte{0x28, 0xb5, 0x2f}) {
+		t.Error("isZstd(truncated magic) = true, want false")
+	}
+}
+
+func TestWriteCompressedZstdRoundTrip(t *testing.T) {
+	p := testCPUProfile()
+
+	var buf bytes.Buffer
+	if err := p.WriteCompressed(&buf, CodecZstd); err != nil {
+		t.Fatalf("WriteCompressed(CodecZstd): %v", err)
+	}
+	if !isZstd(buf.Bytes()) {
+		t.Fatal("WriteCompressed(CodecZstd) output does not start with the zstd magic")
+	}
+
+	got, err := Parse(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got.DurationNanos != p.DurationNanos || got.Period != p.Period {
+		t.Errorf("round-tripped profile = %+v, want DurationNanos=%d Period=%d", got, p.DurationNanos, p.Period)
+	}
+	if len(got.Sample) != len(p.Sample) {
+		t.Errorf("len(Sample) = %d, want %d", len(got.Sample), len(p.Sample))
+	}
+}
+
+func TestWriteCompressedGzip(t *testing.T) {
+	p := testCPUProfile()
+
+	var buf bytes.Buffer
+	if err := p.WriteCompressed(&buf, CodecGzip); err != nil {
+		t.Fatalf("WriteCompressed(CodecGzip): %v", err)
+	}
+
+	got, err := Parse(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(got.Sample) != len(p.Sample) {
+		t.Errorf("len(Sample) = %d, want %d", len(got.Sample), len(p.Sample))
+	}
+}
+
+func TestWriteCompressedUnknownCodec(t *testing.T) {
+	p := testCPUProfile()
+	if err := p.WriteCompressed(&bytes.Buffer{}, Codec(99)); err == nil {
+		t.Error("WriteCompressed with an unknown codec succeeded, want error")
+	}
+}
@@ -0,0 +1,588 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Decoder reads a profile.proto-encoded profile one sample at a
+// time, resolving each Sample's Location references against a header
+// built on a single streaming pass over the input. Samples are
+// spooled to a backing file as they are scanned rather than held in
+// memory, so NextSample's working set is one sample at a time
+// regardless of how many the profile contains.
+type Decoder struct {
+	header       *Profile
+	stringTable  []string
+	locationByID map[uint64]*Location
+
+	spool       *os.File
+	spoolOffset int64
+	sampleSpans []sampleSpan
+	next        int
+}
+
+// sampleSpan locates one sample's encoded bytes within d.spool.
+type sampleSpan struct {
+	offset, length int64
+}
+
+// str looks up a string table index, returning "" for the reserved
+// index 0 or an out-of-range index.
+func (d *Decoder) str(x int64) string {
+	if x == 0 || int(x) >= len(d.stringTable) {
+		return ""
+	}
+	return d.stringTable[x]
+}
+
+// NewDecoder reads a profile.proto-encoded profile from r, which may
+// be gzip-compressed, and returns a Decoder ready to yield its
+// samples. The returned Decoder's Header has every field populated
+// except Sample. Call Close when done to release the Decoder's
+// backing file.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	br := bufio.NewReader(r)
+	var in byteReader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
+		in = bufio.NewReader(gz)
+	}
+
+	spool, err := os.CreateTemp("", "pprof-decoder-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating sample spool: %v", err)
+	}
+	// Unlinking now means the backing space is reclaimed as soon as
+	// spool is closed (or the process exits), with no explicit
+	// cleanup required beyond Close.
+	os.Remove(spool.Name())
+
+	d := &Decoder{
+		header:       &Profile{},
+		locationByID: make(map[uint64]*Location),
+		spool:        spool,
+	}
+	if err := d.decodeHeader(in); err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("decoding profile: %v", err)
+	}
+	return d, nil
+}
+
+// Close releases the Decoder's backing file. It is safe to call Close
+// before exhausting NextSample.
+func (d *Decoder) Close() error {
+	return d.spool.Close()
+}
+
+// Header returns the profile's header: every field except Sample,
+// which is read incrementally via NextSample.
+func (d *Decoder) Header() *Profile {
+	return d.header
+}
+
+// NextSample decodes and returns the next sample in the profile. It
+// returns io.EOF once every sample has been returned.
+func (d *Decoder) NextSample() (*Sample, error) {
+	if d.next >= len(d.sampleSpans) {
+		return nil, io.EOF
+	}
+	span := d.sampleSpans[d.next]
+	d.next++
+	buf := make([]byte, span.length)
+	if _, err := d.spool.ReadAt(buf, span.offset); err != nil {
+		return nil, fmt.Errorf("reading spooled sample: %v", err)
+	}
+	return d.decodeSample(buf)
+}
+
+// decodeHeader makes a single streaming pass over the top-level
+// fields of the encoded profile, without ever materializing the
+// whole payload in memory. Field 2 (sample) entries are copied
+// straight to d.spool for later, one-at-a-time decoding via
+// NextSample; every other field is small enough to decode
+// immediately.
+func (d *Decoder) decodeHeader(r byteReader) error {
+	var (
+		stringTable   []string
+		mappings      []*rawMapping
+		locations     []*rawLocation
+		functions     []*rawFunction
+		sampleTypes   []*rawValueType
+		periodType    *rawValueType
+		commentX      []int64
+		dropFramesX   int64
+		keepFramesX   int64
+		defaultTypeX  int64
+		period        int64
+		timeNanos     int64
+		durationNanos int64
+	)
+
+	for {
+		tag, err := readVarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		num := int(tag >> 3)
+		typ := int(tag & 7)
+
+		switch typ {
+		case 0:
+			v, err := readVarint(r)
+			if err != nil {
+				return err
+			}
+			switch num {
+			case 7:
+				dropFramesX = int64(v)
+			case 8:
+				keepFramesX = int64(v)
+			case 9:
+				timeNanos = int64(v)
+			case 10:
+				durationNanos = int64(v)
+			case 12:
+				period = int64(v)
+			case 13:
+				commentX = append(commentX, int64(v))
+			case 14:
+				defaultTypeX = int64(v)
+			}
+		case 2:
+			length, err := readVarint(r)
+			if err != nil {
+				return err
+			}
+			if num == 2 {
+				n, err := io.CopyN(d.spool, r, int64(length))
+				if err != nil {
+					return fmt.Errorf("spooling sample: %v", err)
+				}
+				d.sampleSpans = append(d.sampleSpans, sampleSpan{offset: d.spoolOffset, length: n})
+				d.spoolOffset += n
+				continue
+			}
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return fmt.Errorf("reading field %d: %v", num, err)
+			}
+			switch num {
+			case 1:
+				vt, err := decodeValueType(buf)
+				if err != nil {
+					return err
+				}
+				sampleTypes = append(sampleTypes, vt)
+			case 3:
+				m, err := decodeMapping(buf)
+				if err != nil {
+					return err
+				}
+				mappings = append(mappings, m)
+			case 4:
+				l, err := decodeLocation(buf)
+				if err != nil {
+					return err
+				}
+				locations = append(locations, l)
+			case 5:
+				fn, err := decodeFunction(buf)
+				if err != nil {
+					return err
+				}
+				functions = append(functions, fn)
+			case 6:
+				stringTable = append(stringTable, string(buf))
+			case 11:
+				pt, err := decodeValueType(buf)
+				if err != nil {
+					return err
+				}
+				periodType = pt
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d for field %d", typ, num)
+		}
+	}
+
+	d.stringTable = stringTable
+	str := d.str
+
+	p := d.header
+	for _, vt := range sampleTypes {
+		p.SampleType = append(p.SampleType, &ValueType{Type: str(vt.typeX), Unit: str(vt.unitX)})
+	}
+	if periodType != nil {
+		p.PeriodType = &ValueType{Type: str(periodType.typeX), Unit: str(periodType.unitX)}
+	}
+	p.Period = period
+	p.TimeNanos = timeNanos
+	p.DurationNanos = durationNanos
+	p.DropFrames = str(dropFramesX)
+	p.KeepFrames = str(keepFramesX)
+	p.DefaultSampleType = str(defaultTypeX)
+	for _, c := range commentX {
+		p.Comments = append(p.Comments, str(c))
+	}
+
+	functionByID := make(map[uint64]*Function, len(functions))
+	for _, rf := range functions {
+		fn := &Function{
+			ID:         rf.id,
+			Name:       str(rf.nameX),
+			SystemName: str(rf.systemNameX),
+			Filename:   str(rf.filenameX),
+			StartLine:  rf.startLine,
+		}
+		p.Function = append(p.Function, fn)
+		functionByID[fn.ID] = fn
+	}
+
+	mappingByID := make(map[uint64]*Mapping, len(mappings))
+	for _, rm := range mappings {
+		m := &Mapping{
+			ID:              rm.id,
+			Start:           rm.start,
+			Limit:           rm.limit,
+			Offset:          rm.offset,
+			File:            str(rm.fileX),
+			BuildID:         str(rm.buildIDX),
+			HasFunctions:    rm.hasFunctions,
+			HasFilenames:    rm.hasFilenames,
+			HasLineNumbers:  rm.hasLineNumbers,
+			HasInlineFrames: rm.hasInlineFrames,
+		}
+		p.Mapping = append(p.Mapping, m)
+		mappingByID[m.ID] = m
+	}
+
+	for _, rl := range locations {
+		l := &Location{
+			ID:      rl.id,
+			Mapping: mappingByID[rl.mappingID],
+			Address: rl.address,
+		}
+		for _, rln := range rl.lines {
+			l.Line = append(l.Line, Line{Function: functionByID[rln.functionID], Line: rln.line})
+		}
+		p.Location = append(p.Location, l)
+		d.locationByID[l.ID] = l
+	}
+
+	return nil
+}
+
+// decodeSample resolves a single Sample message against the header
+// tables already built by decodeHeader. Unlike Location, Function
+// and Mapping references, a Sample's location_id list is resolved on
+// demand here, one sample at a time, rather than up front.
+func (d *Decoder) decodeSample(data []byte) (*Sample, error) {
+	s := &Sample{}
+	var labels []rawLabel
+
+	b := data
+	for len(b) > 0 {
+		f, rest, err := decodeField(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+		switch f.num {
+		case 1:
+			loc, ok := d.locationByID[f.u64]
+			if !ok {
+				return nil, fmt.Errorf("sample referenced unknown location id %d", f.u64)
+			}
+			s.Location = append(s.Location, loc)
+		case 2:
+			s.Value = append(s.Value, int64(f.u64))
+		case 3:
+			lbl, err := decodeLabel(f.buf)
+			if err != nil {
+				return nil, err
+			}
+			labels = append(labels, lbl)
+		}
+	}
+
+	for _, lbl := range labels {
+		key := d.str(lbl.keyX)
+		if lbl.strX != 0 {
+			if s.Label == nil {
+				s.Label = make(map[string][]string)
+			}
+			s.Label[key] = append(s.Label[key], d.str(lbl.strX))
+		} else {
+			if s.NumLabel == nil {
+				s.NumLabel = make(map[string][]int64)
+			}
+			s.NumLabel[key] = append(s.NumLabel[key], lbl.numX)
+		}
+	}
+
+	return s, nil
+}
+
+type rawValueType struct {
+	typeX, unitX int64
+}
+
+type rawMapping struct {
+	id, start, limit, offset                                    uint64
+	fileX, buildIDX                                             int64
+	hasFunctions, hasFilenames, hasLineNumbers, hasInlineFrames bool
+}
+
+type rawLine struct {
+	functionID uint64
+	line       int64
+}
+
+type rawLocation struct {
+	id, mappingID, address uint64
+	lines                  []rawLine
+}
+
+type rawFunction struct {
+	id                                       uint64
+	nameX, systemNameX, filenameX, startLine int64
+}
+
+type rawLabel struct {
+	keyX, strX, numX int64
+}
+
+func decodeValueType(buf []byte) (*rawValueType, error) {
+	vt := &rawValueType{}
+	for len(buf) > 0 {
+		f, rest, err := decodeField(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		switch f.num {
+		case 1:
+			vt.typeX = int64(f.u64)
+		case 2:
+			vt.unitX = int64(f.u64)
+		}
+	}
+	return vt, nil
+}
+
+func decodeMapping(buf []byte) (*rawMapping, error) {
+	m := &rawMapping{}
+	for len(buf) > 0 {
+		f, rest, err := decodeField(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		switch f.num {
+		case 1:
+			m.id = f.u64
+		case 2:
+			m.start = f.u64
+		case 3:
+			m.limit = f.u64
+		case 4:
+			m.offset = f.u64
+		case 5:
+			m.fileX = int64(f.u64)
+		case 6:
+			m.buildIDX = int64(f.u64)
+		case 7:
+			m.hasFunctions = f.u64 != 0
+		case 8:
+			m.hasFilenames = f.u64 != 0
+		case 9:
+			m.hasLineNumbers = f.u64 != 0
+		case 10:
+			m.hasInlineFrames = f.u64 != 0
+		}
+	}
+	return m, nil
+}
+
+func decodeLocation(buf []byte) (*rawLocation, error) {
+	l := &rawLocation{}
+	for len(buf) > 0 {
+		f, rest, err := decodeField(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		switch f.num {
+		case 1:
+			l.id = f.u64
+		case 2:
+			l.mappingID = f.u64
+		case 3:
+			l.address = f.u64
+		case 4:
+			ln, err := decodeLine(f.buf)
+			if err != nil {
+				return nil, err
+			}
+			l.lines = append(l.lines, ln)
+		}
+	}
+	return l, nil
+}
+
+func decodeLine(buf []byte) (rawLine, error) {
+	ln := rawLine{}
+	for len(buf) > 0 {
+		f, rest, err := decodeField(buf)
+		if err != nil {
+			return ln, err
+		}
+		buf = rest
+		switch f.num {
+		case 1:
+			ln.functionID = f.u64
+		case 2:
+			ln.line = int64(f.u64)
+		}
+	}
+	return ln, nil
+}
+
+func decodeFunction(buf []byte) (*rawFunction, error) {
+	fn := &rawFunction{}
+	for len(buf) > 0 {
+		f, rest, err := decodeField(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		switch f.num {
+		case 1:
+			fn.id = f.u64
+		case 2:
+			fn.nameX = int64(f.u64)
+		case 3:
+			fn.systemNameX = int64(f.u64)
+		case 4:
+			fn.filenameX = int64(f.u64)
+		case 5:
+			fn.startLine = int64(f.u64)
+		}
+	}
+	return fn, nil
+}
+
+func decodeLabel(buf []byte) (rawLabel, error) {
+	var lbl rawLabel
+	for len(buf) > 0 {
+		f, rest, err := decodeField(buf)
+		if err != nil {
+			return lbl, err
+		}
+		buf = rest
+		switch f.num {
+		case 1:
+			lbl.keyX = int64(f.u64)
+		case 2:
+			lbl.strX = int64(f.u64)
+		case 3:
+			lbl.numX = int64(f.u64)
+		}
+	}
+	return lbl, nil
+}
+
+// byteReader is what decodeHeader needs from its input: single-byte
+// reads to decode varints, plus bulk reads to pull in field values.
+// *bufio.Reader satisfies this.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readVarint decodes a single protobuf varint from r.
+func readVarint(r io.ByteReader) (uint64, error) {
+	var x uint64
+	var s uint
+	for i := 0; i < 10; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b < 0x80 {
+			return x | uint64(b)<<s, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+	return 0, fmt.Errorf("malformed varint")
+}
+
+// protoField is a single decoded protobuf wire field: either a
+// varint (typ == 0) or a length-delimited payload (typ == 2), which
+// is all the profile.proto schema uses.
+type protoField struct {
+	num int
+	typ int
+	u64 uint64
+	buf []byte
+}
+
+func decodeField(b []byte) (protoField, []byte, error) {
+	var f protoField
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return f, nil, fmt.Errorf("malformed field tag")
+	}
+	b = b[n:]
+	f.num = int(tag >> 3)
+	f.typ = int(tag & 7)
+	switch f.typ {
+	case 0:
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return f, nil, fmt.Errorf("malformed varint field %d", f.num)
+		}
+		f.u64 = v
+		return f, b[n:], nil
+	case 2:
+		l, n := binary.Uvarint(b)
+		if n <= 0 {
+			return f, nil, fmt.Errorf("malformed length-delimited field %d", f.num)
+		}
+		b = b[n:]
+		if uint64(len(b)) < l {
+			return f, nil, fmt.Errorf("truncated field %d", f.num)
+		}
+		f.buf = b[:l]
+		return f, b[l:], nil
+	default:
+		return f, nil, fmt.Errorf("unsupported wire type %d for field %d", f.typ, f.num)
+	}
+}
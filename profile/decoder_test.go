@@ -0,0 +1,204 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// appendVarintField appends a wire-type-0 (varint) field to buf.
+func appendVarintField(buf []byte, num int, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(num)<<3|0)
+	return binary.AppendUvarint(buf, v)
+}
+
+// appendBytesField appends a wire-type-2 (length-delimited) field to buf.
+func appendBytesField(buf []byte, num int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(num)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// testEncodedProfile hand-assembles a profile.proto-encoded Profile
+// with every top-level field populated and two samples, using the
+// same varint/field-tag primitives decoder.go decodes with, since
+// this tree has no marshal to round-trip through instead.
+func testEncodedProfile(t *testing.T) []byte {
+	t.Helper()
+
+	strs := []string{"", "samples", "count", "cpu", "nanoseconds", "main.main", "main.go", "dropme", "keepme", "hello", "world"}
+	idx := make(map[string]int, len(strs))
+	for i, s := range strs {
+		idx[s] = i
+	}
+
+	var valueType = func(typ, unit string) []byte {
+		var b []byte
+		b = appendVarintField(b, 1, uint64(idx[typ]))
+		b = appendVarintField(b, 2, uint64(idx[unit]))
+		return b
+	}
+
+	var mapping []byte
+	mapping = appendVarintField(mapping, 1, 1) // id
+	mapping = appendVarintField(mapping, 2, 0x1000)
+	mapping = appendVarintField(mapping, 3, 0x2000)
+	mapping = appendVarintField(mapping, 7, 1) // has_functions
+
+	var line []byte
+	line = appendVarintField(line, 1, 1) // function_id
+	line = appendVarintField(line, 2, 10)
+
+	var location []byte
+	location = appendVarintField(location, 1, 1) // id
+	location = appendVarintField(location, 2, 1) // mapping_id
+	location = appendVarintField(location, 3, 0x1000)
+	location = appendBytesField(location, 4, line)
+
+	var function []byte
+	function = appendVarintField(function, 1, 1) // id
+	function = appendVarintField(function, 2, uint64(idx["main.main"]))
+	function = appendVarintField(function, 3, uint64(idx["main.main"]))
+	function = appendVarintField(function, 4, uint64(idx["main.go"]))
+
+	var sample1, sample2 []byte
+	sample1 = appendVarintField(sample1, 1, 1) // location_id
+	sample1 = appendVarintField(sample1, 2, 42)
+	sample2 = appendVarintField(sample2, 1, 1)
+	sample2 = appendVarintField(sample2, 2, 43)
+
+	var buf []byte
+	buf = appendBytesField(buf, 1, valueType("samples", "count"))
+	buf = appendBytesField(buf, 3, mapping)
+	buf = appendBytesField(buf, 4, location)
+	buf = appendBytesField(buf, 5, function)
+	for _, s := range strs {
+		buf = appendBytesField(buf, 6, []byte(s))
+	}
+	buf = appendVarintField(buf, 7, uint64(idx["dropme"]))
+	buf = appendVarintField(buf, 8, uint64(idx["keepme"]))
+	buf = appendVarintField(buf, 9, 123456789)
+	buf = appendVarintField(buf, 10, 987654321)
+	buf = appendBytesField(buf, 11, valueType("cpu", "nanoseconds"))
+	buf = appendVarintField(buf, 12, 1000)
+	buf = appendVarintField(buf, 13, uint64(idx["hello"]))
+	buf = appendVarintField(buf, 13, uint64(idx["world"]))
+	buf = appendVarintField(buf, 14, uint64(idx["samples"]))
+	buf = appendBytesField(buf, 2, sample1)
+	buf = appendBytesField(buf, 2, sample2)
+
+	return buf
+}
+
+func checkDecodedHeader(t *testing.T, h *Profile) {
+	t.Helper()
+	if h.DropFrames != "dropme" {
+		t.Errorf("DropFrames = %q, want %q", h.DropFrames, "dropme")
+	}
+	if h.KeepFrames != "keepme" {
+		t.Errorf("KeepFrames = %q, want %q", h.KeepFrames, "keepme")
+	}
+	if h.TimeNanos != 123456789 {
+		t.Errorf("TimeNanos = %d, want 123456789", h.TimeNanos)
+	}
+	if h.DurationNanos != 987654321 {
+		t.Errorf("DurationNanos = %d, want 987654321", h.DurationNanos)
+	}
+	if h.PeriodType == nil || h.PeriodType.Type != "cpu" || h.PeriodType.Unit != "nanoseconds" {
+		t.Errorf("PeriodType = %+v, want cpu/nanoseconds", h.PeriodType)
+	}
+	if h.Period != 1000 {
+		t.Errorf("Period = %d, want 1000", h.Period)
+	}
+	if got := h.Comments; len(got) != 2 || got[0] != "hello" || got[1] != "world" {
+		t.Errorf("Comments = %v, want [hello world]", got)
+	}
+	if h.DefaultSampleType != "samples" {
+		t.Errorf("DefaultSampleType = %q, want %q", h.DefaultSampleType, "samples")
+	}
+}
+
+func TestDecoderHeaderAndSamples(t *testing.T) {
+	d, err := NewDecoder(bytes.NewReader(testEncodedProfile(t)))
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	defer d.Close()
+
+	checkDecodedHeader(t, d.Header())
+
+	var values []int64
+	for {
+		s, err := d.NextSample()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextSample: %v", err)
+		}
+		if len(s.Location) != 1 || s.Location[0].ID != 1 {
+			t.Errorf("sample location = %+v, want just location 1", s.Location)
+		}
+		values = append(values, s.Value...)
+	}
+	if want := []int64{42, 43}; !equalInt64(values, want) {
+		t.Errorf("sample values = %v, want %v", values, want)
+	}
+}
+
+func TestDecoderGzipCompressed(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(testEncodedProfile(t)); err != nil {
+		t.Fatalf("writing gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("closing gzip: %v", err)
+	}
+
+	d, err := NewDecoder(&gz)
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	defer d.Close()
+
+	checkDecodedHeader(t, d.Header())
+
+	if _, err := d.NextSample(); err != nil {
+		t.Fatalf("NextSample: %v", err)
+	}
+	if _, err := d.NextSample(); err != nil {
+		t.Fatalf("NextSample: %v", err)
+	}
+	if _, err := d.NextSample(); err != io.EOF {
+		t.Errorf("NextSample after last sample: err = %v, want io.EOF", err)
+	}
+}
+
+func equalInt64(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import "fmt"
+
+// Delta computes the difference between cur and base, two compatible
+// profiles collected at different points in time.
+func Delta(base, cur *Profile) (*Profile, error) {
+	d := cur.Copy()
+	if err := d.Sub(base); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Sub subtracts base from p, in place. p and base must be compatible
+// profiles, as defined by compatible(). Sample values that net to
+// zero across all sample types are dropped. DurationNanos is set to
+// the elapsed time between the two profiles when both have a
+// TimeNanos set.
+func (p *Profile) Sub(base *Profile) error {
+	if err := compatible(p, base); err != nil {
+		return err
+	}
+
+	neg := base.Copy()
+	for _, s := range neg.Sample {
+		for i, v := range s.Value {
+			s.Value[i] = -v
+		}
+	}
+	neg.DurationNanos = -neg.DurationNanos
+
+	cur := p.Copy()
+	merged, err := Merge([]*Profile{cur, neg})
+	if err != nil {
+		return fmt.Errorf("delta: %v", err)
+	}
+
+	var kept []*Sample
+	for _, s := range merged.Sample {
+		if !allZero(s.Value) {
+			kept = append(kept, s)
+		}
+	}
+	merged.Sample = kept
+
+	if base.TimeNanos != 0 && cur.TimeNanos != 0 {
+		merged.DurationNanos = cur.TimeNanos - base.TimeNanos
+	}
+
+	*p = *merged
+	return nil
+}
+
+func allZero(values []int64) bool {
+	for _, v := range values {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
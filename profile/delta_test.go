@@ -0,0 +1,64 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import "testing"
+
+func TestDeltaDropsUnchangedSamples(t *testing.T) {
+	base := testCPUProfile()
+	base.TimeNanos = 1000
+
+	cur := testCPUProfile()
+	cur.TimeNanos = 2000
+	cur.Sample[0].Value[0] = 1 // identical to base: should cancel out
+
+	d, err := Delta(base, cur)
+	if err != nil {
+		t.Fatalf("Delta: %v", err)
+	}
+	if len(d.Sample) != 0 {
+		t.Errorf("len(Sample) = %d, want 0 (unchanged sample should have been dropped)", len(d.Sample))
+	}
+	if d.DurationNanos != 1000 {
+		t.Errorf("DurationNanos = %d, want 1000", d.DurationNanos)
+	}
+}
+
+func TestDeltaKeepsChangedSamples(t *testing.T) {
+	base := testCPUProfile()
+	cur := testCPUProfile()
+	cur.Sample[0].Value[0] = 5
+
+	d, err := Delta(base, cur)
+	if err != nil {
+		t.Fatalf("Delta: %v", err)
+	}
+	if len(d.Sample) != 1 {
+		t.Fatalf("len(Sample) = %d, want 1", len(d.Sample))
+	}
+	if got := d.Sample[0].Value[0]; got != 4 {
+		t.Errorf("delta sample value = %d, want 4", got)
+	}
+}
+
+func TestSubRejectsIncompatibleProfiles(t *testing.T) {
+	base := testCPUProfile()
+	base.SampleType = []*ValueType{{Type: "alloc_objects", Unit: "count"}}
+	cur := testCPUProfile()
+
+	if err := cur.Sub(base); err == nil {
+		t.Fatal("Sub of incompatible profiles succeeded, want error")
+	}
+}
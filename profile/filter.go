@@ -0,0 +1,164 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file provides filtering of profiles, by applying filters to
+// all samples.
+
+package profile
+
+import "regexp"
+
+// TagMatch selects a tag and reports whether it matches a sample, to
+// implement filtering on tags in FilterSamplesByTag.
+type TagMatch func(s *Sample) bool
+
+// FilterSamplesByName filters the samples in a profile and only
+// keeps samples where at least one frame matches focus and no frame
+// matches ignore. Returns true is the corresponding regexp matched
+// at least one sample. Each sample's frames are additionally
+// filtered: frames matching hide are removed, and if show is
+// non-nil, only frames matching show are kept.
+func (p *Profile) FilterSamplesByName(focus, ignore, hide, show *regexp.Regexp) (fm, im, hm, hidem bool) {
+	focusOrIgnore := make(map[uint64]bool)
+	hidden := make(map[uint64]bool)
+	for _, l := range p.Location {
+		if ignore != nil && l.matchesName(ignore) {
+			im = true
+			focusOrIgnore[l.ID] = false
+		} else if focus == nil || l.matchesName(focus) {
+			fm = true
+			focusOrIgnore[l.ID] = true
+		}
+
+		if hide != nil && l.matchesName(hide) {
+			hidem = true
+			l.Line = l.unmatchedLines(hide)
+			if len(l.Line) == 0 {
+				hidden[l.ID] = true
+			}
+		}
+		if show != nil {
+			l.Line = l.matchedLines(show)
+			if len(l.Line) > 0 {
+				hm = true
+				hidden[l.ID] = false
+			} else {
+				hidden[l.ID] = true
+			}
+		}
+	}
+
+	s := make([]*Sample, 0, len(p.Sample))
+	for _, sample := range p.Sample {
+		if focusedAndNotIgnored(sample.Location, focusOrIgnore) {
+			if len(hidden) > 0 {
+				var locs []*Location
+				for _, loc := range sample.Location {
+					if !hidden[loc.ID] {
+						locs = append(locs, loc)
+					}
+				}
+				sample.Location = locs
+			}
+			s = append(s, sample)
+		}
+	}
+	p.Sample = s
+	if err := p.prune(); err != nil {
+		panic(err)
+	}
+
+	return fm, im, hm, hidem
+}
+
+// matchesName reports whether a line in the location matches the
+// regular expression, either through the function name or the
+// system name.
+func (loc *Location) matchesName(re *regexp.Regexp) bool {
+	for _, ln := range loc.Line {
+		if fn := ln.Function; fn != nil {
+			if re.MatchString(fn.Name) || re.MatchString(fn.SystemName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unmatchedLines returns the lines in loc that do not match re.
+func (loc *Location) unmatchedLines(re *regexp.Regexp) []Line {
+	var lines []Line
+	for _, ln := range loc.Line {
+		if fn := ln.Function; fn != nil && (re.MatchString(fn.Name) || re.MatchString(fn.SystemName)) {
+			continue
+		}
+		lines = append(lines, ln)
+	}
+	return lines
+}
+
+// matchedLines returns the lines in loc that match re.
+func (loc *Location) matchedLines(re *regexp.Regexp) []Line {
+	var lines []Line
+	for _, ln := range loc.Line {
+		if fn := ln.Function; fn != nil && (re.MatchString(fn.Name) || re.MatchString(fn.SystemName)) {
+			lines = append(lines, ln)
+		}
+	}
+	return lines
+}
+
+// focusedAndNotIgnored looks up a slice of ids against a map of
+// focus/ignore marks. It returns true if there is at least one
+// focus marked id and no ignore marked ones.
+func focusedAndNotIgnored(locs []*Location, m map[uint64]bool) bool {
+	var f bool
+	for _, loc := range locs {
+		if focus, ok := m[loc.ID]; ok {
+			if !focus {
+				return false
+			}
+			f = true
+		}
+	}
+	return f
+}
+
+// FilterSamplesByTag removes all samples from the profile, except
+// those that match focus and do not match ignore. Returns true is the
+// corresponding filter matched at least one sample.
+func (p *Profile) FilterSamplesByTag(focus, ignore TagMatch) (fm, im bool) {
+	samples := make([]*Sample, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		focused, ignored := true, false
+		if focus != nil {
+			if focused = focus(s); focused {
+				fm = true
+			}
+		}
+		if ignore != nil {
+			if ignored = ignore(s); ignored {
+				im = true
+			}
+		}
+		if focused && !ignored {
+			samples = append(samples, s)
+		}
+	}
+	p.Sample = samples
+	if err := p.prune(); err != nil {
+		panic(err)
+	}
+	return
+}
@@ -0,0 +1,156 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFilterSamplesByNameFocus(t *testing.T) {
+	keep := &Function{ID: 1, Name: "keepme"}
+	drop := &Function{ID: 2, Name: "dropme"}
+	lKeep := &Location{ID: 1, Line: []Line{{Function: keep, Line: 1}}}
+	lDrop := &Location{ID: 2, Line: []Line{{Function: drop, Line: 1}}}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*Function{keep, drop},
+		Location:   []*Location{lKeep, lDrop},
+		Sample: []*Sample{
+			{Location: []*Location{lKeep}, Value: []int64{1}},
+			{Location: []*Location{lDrop}, Value: []int64{1}},
+		},
+	}
+
+	fm, _, _, _ := p.FilterSamplesByName(regexp.MustCompile("keepme"), nil, nil, nil)
+	if !fm {
+		t.Error("fm = false, want true")
+	}
+	if len(p.Sample) != 1 {
+		t.Fatalf("len(Sample) = %d, want 1", len(p.Sample))
+	}
+	if p.Sample[0].Location[0].ID != lKeep.ID {
+		t.Errorf("surviving sample has location %d, want %d", p.Sample[0].Location[0].ID, lKeep.ID)
+	}
+}
+
+// TestFilterSamplesByNameHidePartialMatch guards against hiding an
+// entire Location when only one of its inlined frames matches hide:
+// the non-matching frame must survive.
+func TestFilterSamplesByNameHidePartialMatch(t *testing.T) {
+	hidden := &Function{ID: 1, Name: "hideme"}
+	kept := &Function{ID: 2, Name: "keepme"}
+	loc := &Location{ID: 1, Line: []Line{
+		{Function: hidden, Line: 1},
+		{Function: kept, Line: 2},
+	}}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*Function{hidden, kept},
+		Location:   []*Location{loc},
+		Sample:     []*Sample{{Location: []*Location{loc}, Value: []int64{1}}},
+	}
+
+	_, _, _, hidem := p.FilterSamplesByName(nil, nil, regexp.MustCompile("hideme"), nil)
+	if !hidem {
+		t.Error("hidem = false, want true")
+	}
+	if len(p.Sample) != 1 {
+		t.Fatalf("len(Sample) = %d, want 1 (location still has a surviving frame)", len(p.Sample))
+	}
+	if len(p.Sample[0].Location) != 1 {
+		t.Fatalf("len(Sample[0].Location) = %d, want 1", len(p.Sample[0].Location))
+	}
+	lines := p.Sample[0].Location[0].Line
+	if len(lines) != 1 || lines[0].Function != kept {
+		t.Errorf("surviving location's lines = %v, want just the keepme frame", lines)
+	}
+}
+
+func TestFilterSamplesByNameHideWholeLocation(t *testing.T) {
+	hidden := &Function{ID: 1, Name: "hideme"}
+	loc := &Location{ID: 1, Line: []Line{{Function: hidden, Line: 1}}}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*Function{hidden},
+		Location:   []*Location{loc},
+		Sample:     []*Sample{{Location: []*Location{loc}, Value: []int64{1}}},
+	}
+
+	_, _, _, hidem := p.FilterSamplesByName(nil, nil, regexp.MustCompile("hideme"), nil)
+	if !hidem {
+		t.Error("hidem = false, want true")
+	}
+	if len(p.Sample) != 1 {
+		t.Fatalf("len(Sample) = %d, want 1 (hide trims frames, it does not drop samples)", len(p.Sample))
+	}
+	if len(p.Sample[0].Location) != 0 {
+		t.Errorf("len(Sample[0].Location) = %d, want 0", len(p.Sample[0].Location))
+	}
+}
+
+// TestFilterSamplesByNamePanicsOnInvalidProfile guards the prune()
+// error path: a profile that fails CheckValid after filtering must
+// panic rather than leave the caller with a silently broken Profile.
+func TestFilterSamplesByNamePanicsOnInvalidProfile(t *testing.T) {
+	fn := &Function{ID: 1, Name: "keepme"}
+	loc := &Location{ID: 1, Line: []Line{{Function: fn, Line: 1}}}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*Function{fn},
+		Location:   []*Location{loc},
+		// Value has one more entry than SampleType, which CheckValid rejects.
+		Sample: []*Sample{{Location: []*Location{loc}, Value: []int64{1, 2}}},
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("FilterSamplesByName on an invalid profile did not panic")
+		}
+	}()
+	p.FilterSamplesByName(regexp.MustCompile("keepme"), nil, nil, nil)
+}
+
+func TestFilterSamplesByTag(t *testing.T) {
+	loc := &Location{ID: 1}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Location:   []*Location{loc},
+		Sample: []*Sample{
+			{Location: []*Location{loc}, Value: []int64{1}, Label: map[string][]string{"env": {"prod"}}},
+			{Location: []*Location{loc}, Value: []int64{1}, Label: map[string][]string{"env": {"staging"}}},
+		},
+	}
+
+	focusProd := func(s *Sample) bool {
+		for _, v := range s.Label["env"] {
+			if v == "prod" {
+				return true
+			}
+		}
+		return false
+	}
+
+	fm, _ := p.FilterSamplesByTag(focusProd, nil)
+	if !fm {
+		t.Error("fm = false, want true")
+	}
+	if len(p.Sample) != 1 {
+		t.Fatalf("len(Sample) = %d, want 1", len(p.Sample))
+	}
+	if p.Sample[0].Label["env"][0] != "prod" {
+		t.Errorf("surviving sample env = %v, want prod", p.Sample[0].Label["env"])
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Merge merges all the profiles in srcs into a single Profile.
+// Returns a new profile independent of the input profiles. The new
+// profile is checked for consistency with CheckValid.
+func Merge(srcs []*Profile) (*Profile, error) {
+	if len(srcs) == 0 {
+		return nil, fmt.Errorf("no profiles to merge")
+	}
+	p, err := combineHeaders(srcs)
+	if err != nil {
+		return nil, err
+	}
+
+	pm := &profileMerger{
+		p:         p,
+		samples:   make(map[sampleKey]*Sample),
+		locations: make(map[locationKey]*Location),
+		functions: make(map[functionKey]*Function),
+		mappings:  make(map[mappingKey]*Mapping),
+	}
+
+	for _, src := range srcs {
+		// Clone the input profile before modifying it, srcs are not
+		// supposed to be modified by Merge.
+		src = src.Copy()
+
+		// Sum DurationNanos across all sources, and keep the earliest
+		// of the two TimeNanos values if both are set.
+		p.DurationNanos += src.DurationNanos
+		if src.TimeNanos != 0 && (p.TimeNanos == 0 || src.TimeNanos < p.TimeNanos) {
+			p.TimeNanos = src.TimeNanos
+		}
+
+		pm.mapMappings(src)
+		pm.mapFunctions(src)
+		pm.mapLocations(src)
+		pm.mapSamples(src)
+		p.Comments = unionComments(p.Comments, src.Comments)
+	}
+
+	if err := p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("merge result invalid: %v", err)
+	}
+	return p, nil
+}
+
+// Merge adds profile src to p, updating p in place.
+//
+// Merge requires the profiles to have identical SampleType, and that
+// profiles must be compatible.
+func (p *Profile) Merge(src *Profile) error {
+	merged, err := Merge([]*Profile{p, src})
+	if err != nil {
+		return err
+	}
+	*p = *merged
+	return nil
+}
+
+// combineHeaders checks that all profiles can be merged and returns
+// their combined profile header.
+func combineHeaders(srcs []*Profile) (*Profile, error) {
+	for _, s := range srcs[1:] {
+		if err := compatible(srcs[0], s); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &Profile{
+		SampleType:        make([]*ValueType, len(srcs[0].SampleType)),
+		DropFrames:        srcs[0].DropFrames,
+		KeepFrames:        srcs[0].KeepFrames,
+		PeriodType:        srcs[0].PeriodType,
+		Period:            srcs[0].Period,
+		DefaultSampleType: srcs[0].DefaultSampleType,
+	}
+	copy(p.SampleType, srcs[0].SampleType)
+	return p, nil
+}
+
+// compatible determines if two profiles can be compared/merged.
+// returns nil if the profiles are compatible; otherwise an error with
+// details on the incompatibility.
+func compatible(p, pb *Profile) error {
+	if len(p.SampleType) != len(pb.SampleType) {
+		return fmt.Errorf("incompatible sample types: have %d, want %d", len(pb.SampleType), len(p.SampleType))
+	}
+	for i := range p.SampleType {
+		if p.SampleType[i].Type != pb.SampleType[i].Type || p.SampleType[i].Unit != pb.SampleType[i].Unit {
+			return fmt.Errorf("incompatible sample types: have %v/%v, want %v/%v",
+				pb.SampleType[i].Type, pb.SampleType[i].Unit, p.SampleType[i].Type, p.SampleType[i].Unit)
+		}
+	}
+
+	if pt, pbt := p.PeriodType, pb.PeriodType; pt != nil && pbt != nil &&
+		(pt.Type != pbt.Type || pt.Unit != pbt.Unit) {
+		return fmt.Errorf("incompatible period types: have %v/%v, want %v/%v",
+			pbt.Type, pbt.Unit, pt.Type, pt.Unit)
+	}
+	return nil
+}
+
+// profileMerger accumulates the dedup maps used while folding a set
+// of source profiles into a single destination profile p.
+type profileMerger struct {
+	p *Profile
+
+	// Memoize mappings to avoid duplicates.
+	mappings map[mappingKey]*Mapping
+	// Memoize functions to avoid duplicates.
+	functions map[functionKey]*Function
+	// Memoize locations to avoid duplicates.
+	locations map[locationKey]*Location
+	// Memoize samples to avoid duplicates.
+	samples map[sampleKey]*Sample
+}
+
+// mapMappings adds the mappings from src to the merged profile and
+// records the mapping from src's Mapping pointers to the merged ones.
+func (pm *profileMerger) mapMappings(src *Profile) {
+	for _, m := range src.Mapping {
+		mk := mappingKey{
+			size:    m.Limit - m.Start,
+			offset:  m.Offset,
+			buildID: m.BuildID,
+			file:    m.File,
+		}
+		if mm, ok := pm.mappings[mk]; ok {
+			mm.HasFunctions = mm.HasFunctions && m.HasFunctions
+			mm.HasFilenames = mm.HasFilenames && m.HasFilenames
+			mm.HasLineNumbers = mm.HasLineNumbers && m.HasLineNumbers
+			mm.HasInlineFrames = mm.HasInlineFrames && m.HasInlineFrames
+			src.remapMapping(m, mm)
+			continue
+		}
+		mm := &Mapping{
+			ID:              uint64(len(pm.p.Mapping) + 1),
+			Start:           m.Start,
+			Limit:           m.Limit,
+			Offset:          m.Offset,
+			File:            m.File,
+			BuildID:         m.BuildID,
+			HasFunctions:    m.HasFunctions,
+			HasFilenames:    m.HasFilenames,
+			HasLineNumbers:  m.HasLineNumbers,
+			HasInlineFrames: m.HasInlineFrames,
+		}
+		pm.p.Mapping = append(pm.p.Mapping, mm)
+		pm.mappings[mk] = mm
+		src.remapMapping(m, mm)
+	}
+}
+
+// remapMapping rewrites every Location in p that pointed at from to
+// point at to instead.
+func (p *Profile) remapMapping(from, to *Mapping) {
+	for _, l := range p.Location {
+		if l.Mapping == from {
+			l.Mapping = to
+		}
+	}
+}
+
+// mapFunctions adds the functions from src to the merged profile and
+// rewrites src's Line.Function pointers to the merged functions.
+func (pm *profileMerger) mapFunctions(src *Profile) {
+	remap := make(map[*Function]*Function, len(src.Function))
+	for _, f := range src.Function {
+		fk := functionKey{f.Name, f.SystemName, f.Filename, f.StartLine}
+		if ff, ok := pm.functions[fk]; ok {
+			remap[f] = ff
+			continue
+		}
+		ff := &Function{
+			ID:         uint64(len(pm.p.Function) + 1),
+			Name:       f.Name,
+			SystemName: f.SystemName,
+			Filename:   f.Filename,
+			StartLine:  f.StartLine,
+		}
+		pm.p.Function = append(pm.p.Function, ff)
+		pm.functions[fk] = ff
+		remap[f] = ff
+	}
+	for _, l := range src.Location {
+		for i, ln := range l.Line {
+			if ln.Function != nil {
+				l.Line[i].Function = remap[ln.Function]
+			}
+		}
+	}
+}
+
+// mapLocations adds the locations from src to the merged profile and
+// rewrites src's Sample.Location pointers to the merged locations.
+func (pm *profileMerger) mapLocations(src *Profile) {
+	remap := make(map[*Location]*Location, len(src.Location))
+	for _, l := range src.Location {
+		lk := locationKeyFor(l)
+		if ll, ok := pm.locations[lk]; ok {
+			remap[l] = ll
+			continue
+		}
+		ll := &Location{
+			ID:      uint64(len(pm.p.Location) + 1),
+			Mapping: l.Mapping,
+			Address: l.Address,
+			Line:    append([]Line(nil), l.Line...),
+		}
+		pm.p.Location = append(pm.p.Location, ll)
+		pm.locations[lk] = ll
+		remap[l] = ll
+	}
+	for _, s := range src.Sample {
+		for i, l := range s.Location {
+			s.Location[i] = remap[l]
+		}
+	}
+}
+
+// mapSamples folds src's samples into the merged profile, summing
+// the values of any samples whose (locations, labels, numlabels)
+// tuple is already present.
+func (pm *profileMerger) mapSamples(src *Profile) {
+	for _, s := range src.Sample {
+		sk := sampleKeyFor(s)
+		if ss, ok := pm.samples[sk]; ok {
+			for i, v := range s.Value {
+				ss.Value[i] += v
+			}
+			continue
+		}
+		ss := &Sample{
+			Location: append([]*Location(nil), s.Location...),
+			Value:    append([]int64(nil), s.Value...),
+			Label:    cloneLabels(s.Label),
+			NumLabel: cloneNumLabels(s.NumLabel),
+		}
+		pm.p.Sample = append(pm.p.Sample, ss)
+		pm.samples[sk] = ss
+	}
+}
+
+type mappingKey struct {
+	size, offset  uint64
+	buildID, file string
+}
+
+type functionKey struct {
+	name, systemName, filename string
+	startLine                  int64
+}
+
+// locationKey identifies locations that should be deduped: those with
+// the same mapping, address and line/function tuples.
+type locationKey string
+
+func locationKeyFor(l *Location) locationKey {
+	var mappingID uint64
+	if l.Mapping != nil {
+		mappingID = l.Mapping.ID
+	}
+	key := fmt.Sprintf("%d@%#x", mappingID, l.Address)
+	for _, ln := range l.Line {
+		var fid uint64
+		if ln.Function != nil {
+			fid = ln.Function.ID
+		}
+		key += fmt.Sprintf(":%d:%d", fid, ln.Line)
+	}
+	return locationKey(key)
+}
+
+// sampleKey identifies samples that should be folded together: those
+// with identical locations, labels and numeric labels.
+type sampleKey string
+
+func sampleKeyFor(s *Sample) sampleKey {
+	var key string
+	for _, l := range s.Location {
+		key += fmt.Sprintf("@%d", l.ID)
+	}
+	key += labelsKey(s.Label) + numLabelsKey(s.NumLabel)
+	return sampleKey(key)
+}
+
+func labelsKey(labels map[string][]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var key string
+	for _, k := range keys {
+		vs := append([]string(nil), labels[k]...)
+		sort.Strings(vs)
+		key += fmt.Sprintf("%s=%v#", k, vs)
+	}
+	return key
+}
+
+func numLabelsKey(labels map[string][]int64) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var key string
+	for _, k := range keys {
+		vs := append([]int64(nil), labels[k]...)
+		sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+		key += fmt.Sprintf("%s=%v#", k, vs)
+	}
+	return key
+}
+
+func cloneLabels(labels map[string][]string) map[string][]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string][]string, len(labels))
+	for k, v := range labels {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+func cloneNumLabels(labels map[string][]int64) map[string][]int64 {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string][]int64, len(labels))
+	for k, v := range labels {
+		c[k] = append([]int64(nil), v...)
+	}
+	return c
+}
+
+func unionComments(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	out := append([]string(nil), a...)
+	for _, c := range a {
+		seen[c] = true
+	}
+	for _, c := range b {
+		if !seen[c] {
+			out = append(out, c)
+			seen[c] = true
+		}
+	}
+	return out
+}
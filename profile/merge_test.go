@@ -0,0 +1,75 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import "testing"
+
+func testCPUProfile() *Profile {
+	fn := &Function{ID: 1, Name: "main.main", SystemName: "main.main", Filename: "main.go"}
+	loc := &Location{ID: 1, Address: 0x1000, Line: []Line{{Function: fn, Line: 10}}}
+	return &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*Function{fn},
+		Location:   []*Location{loc},
+		Sample:     []*Sample{{Location: []*Location{loc}, Value: []int64{1}}},
+		PeriodType: &ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     1000,
+	}
+}
+
+func TestMergeSumsDurationNanos(t *testing.T) {
+	a := testCPUProfile()
+	a.DurationNanos = 10
+	b := testCPUProfile()
+	b.DurationNanos = 10
+
+	merged, err := Merge([]*Profile{a, b})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if merged.DurationNanos != 20 {
+		t.Errorf("DurationNanos = %d, want 20", merged.DurationNanos)
+	}
+}
+
+func TestMergeFoldsEquivalentSamples(t *testing.T) {
+	a := testCPUProfile()
+	b := testCPUProfile()
+
+	merged, err := Merge([]*Profile{a, b})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if len(merged.Sample) != 1 {
+		t.Fatalf("len(Sample) = %d, want 1", len(merged.Sample))
+	}
+	if got := merged.Sample[0].Value[0]; got != 2 {
+		t.Errorf("merged sample value = %d, want 2", got)
+	}
+	if len(merged.Location) != 1 || len(merged.Function) != 1 {
+		t.Errorf("merged profile has %d locations, %d functions; want 1, 1 (dedup failed)",
+			len(merged.Location), len(merged.Function))
+	}
+}
+
+func TestMergeRejectsIncompatibleProfiles(t *testing.T) {
+	a := testCPUProfile()
+	b := testCPUProfile()
+	b.SampleType = []*ValueType{{Type: "alloc_objects", Unit: "count"}}
+
+	if _, err := Merge([]*Profile{a, b}); err == nil {
+		t.Fatal("Merge of incompatible profiles succeeded, want error")
+	}
+}
@@ -25,6 +25,8 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 // Profile is an in-memory representation of profile.proto.
@@ -152,6 +154,15 @@ func ParseData(data []byte) (*Profile, error) {
 		if err != nil {
 			return nil, fmt.Errorf("decompressing profile: %v", err)
 		}
+	} else if isZstd(data) {
+		zr, err := zstd.NewReader(bytes.NewBuffer(data))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
+		defer zr.Close()
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
 	}
 	if p, err = ParseUncompressed(data); err != nil {
 		if p, err = parseLegacy(data); err != nil {
@@ -0,0 +1,68 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file provides prune support for profiles, removing Locations,
+// Functions and Mappings that are no longer referenced by any Sample
+// once filtering has dropped samples or frames.
+
+package profile
+
+// prune drops Locations, Functions and Mappings that are not
+// reachable from any remaining Sample, then checks the resulting
+// profile for consistency.
+func (p *Profile) prune() error {
+	reachedLocation := make(map[uint64]bool, len(p.Location))
+	for _, s := range p.Sample {
+		for _, l := range s.Location {
+			reachedLocation[l.ID] = true
+		}
+	}
+
+	reachedFunction := make(map[uint64]bool, len(p.Function))
+	reachedMapping := make(map[uint64]bool, len(p.Mapping))
+	locations := make([]*Location, 0, len(p.Location))
+	for _, l := range p.Location {
+		if !reachedLocation[l.ID] {
+			continue
+		}
+		locations = append(locations, l)
+		if l.Mapping != nil {
+			reachedMapping[l.Mapping.ID] = true
+		}
+		for _, ln := range l.Line {
+			if ln.Function != nil {
+				reachedFunction[ln.Function.ID] = true
+			}
+		}
+	}
+	p.Location = locations
+
+	functions := make([]*Function, 0, len(p.Function))
+	for _, f := range p.Function {
+		if reachedFunction[f.ID] {
+			functions = append(functions, f)
+		}
+	}
+	p.Function = functions
+
+	mappings := make([]*Mapping, 0, len(p.Mapping))
+	for _, m := range p.Mapping {
+		if reachedMapping[m.ID] {
+			mappings = append(mappings, m)
+		}
+	}
+	p.Mapping = mappings
+
+	return p.CheckValid()
+}
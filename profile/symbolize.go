@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import "fmt"
+
+// Symbolizer turns a set of addresses within a mapping into the
+// source lines they correspond to, giving callers a seam to plug in
+// addr2line, llvm-symbolizer, or a build-ID-indexed remote symbol
+// server without forking this package. The returned slice must have
+// one entry per address in addrs, in the same order; an address that
+// could not be resolved should map to a nil or empty []Line.
+type Symbolizer interface {
+	Symbolize(m *Mapping, addrs []uint64) ([][]Line, error)
+}
+
+// Symbolize walks every Location in the profile whose Mapping has not
+// already been fully symbolized (or every Location if force is true),
+// batches their addresses per Mapping, and asks s to resolve them.
+// Resolved lines are installed on the Locations and any new Functions
+// are deduped into p.Function. Each Mapping's Has* fields are updated
+// to reflect what the symbolizer reported.
+func (p *Profile) Symbolize(s Symbolizer, force bool) error {
+	locsByMapping := make(map[*Mapping][]*Location)
+	for _, l := range p.Location {
+		if l.Mapping == nil {
+			continue
+		}
+		if !force && l.Mapping.HasFunctions {
+			continue
+		}
+		locsByMapping[l.Mapping] = append(locsByMapping[l.Mapping], l)
+	}
+	if len(locsByMapping) == 0 {
+		return nil
+	}
+
+	functions := make(map[functionKey]*Function, len(p.Function))
+	for _, f := range p.Function {
+		functions[functionKey{f.Name, f.SystemName, f.Filename, f.StartLine}] = f
+	}
+
+	for m, locs := range locsByMapping {
+		addrs := make([]uint64, len(locs))
+		for i, l := range locs {
+			addrs[i] = l.Address
+		}
+		lines, err := s.Symbolize(m, addrs)
+		if err != nil {
+			return fmt.Errorf("symbolizing mapping %s: %v", m.File, err)
+		}
+		if len(lines) != len(locs) {
+			return fmt.Errorf("symbolizer returned %d results for %d addresses in mapping %s", len(lines), len(locs), m.File)
+		}
+
+		hasFunctions, hasFilenames, hasLineNumbers, hasInlineFrames := true, true, true, true
+		for i, l := range locs {
+			resolved := lines[i]
+			if len(resolved) == 0 {
+				hasFunctions, hasFilenames, hasLineNumbers, hasInlineFrames = false, false, false, false
+				continue
+			}
+			l.Line = make([]Line, len(resolved))
+			for j, ln := range resolved {
+				if ln.Function != nil {
+					ln.Function = p.dedupFunction(ln.Function, functions)
+					if ln.Function.Filename == "" {
+						hasFilenames = false
+					}
+				} else {
+					hasFunctions = false
+					hasFilenames = false
+				}
+				if ln.Line == 0 {
+					hasLineNumbers = false
+				}
+				l.Line[j] = ln
+			}
+			if len(resolved) <= 1 {
+				hasInlineFrames = false
+			}
+		}
+
+		m.HasFunctions = hasFunctions
+		m.HasFilenames = hasFilenames
+		m.HasLineNumbers = hasLineNumbers
+		m.HasInlineFrames = hasInlineFrames
+	}
+
+	return p.CheckValid()
+}
+
+// dedupFunction returns the Function in p.Function equivalent to f,
+// adding f to p.Function and functions if no equivalent exists yet.
+func (p *Profile) dedupFunction(f *Function, functions map[functionKey]*Function) *Function {
+	fk := functionKey{f.Name, f.SystemName, f.Filename, f.StartLine}
+	if existing, ok := functions[fk]; ok {
+		return existing
+	}
+	nf := &Function{
+		ID:         uint64(len(p.Function) + 1),
+		Name:       f.Name,
+		SystemName: f.SystemName,
+		Filename:   f.Filename,
+		StartLine:  f.StartLine,
+	}
+	p.Function = append(p.Function, nf)
+	functions[fk] = nf
+	return nf
+}
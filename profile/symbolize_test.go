@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import "testing"
+
+// stubSymbolizer resolves addresses found in its table and leaves the
+// rest unresolved, to exercise partially-symbolized mappings.
+type stubSymbolizer map[uint64][]Line
+
+func (s stubSymbolizer) Symbolize(m *Mapping, addrs []uint64) ([][]Line, error) {
+	out := make([][]Line, len(addrs))
+	for i, a := range addrs {
+		out[i] = s[a]
+	}
+	return out, nil
+}
+
+func TestSymbolizeResolvesLocations(t *testing.T) {
+	m := &Mapping{ID: 1, File: "a.out"}
+	loc := &Location{ID: 1, Mapping: m, Address: 0x1000}
+	p := &Profile{Mapping: []*Mapping{m}, Location: []*Location{loc}}
+
+	sym := stubSymbolizer{0x1000: []Line{{Function: &Function{Name: "main.main", Filename: "main.go"}, Line: 10}}}
+	if err := p.Symbolize(sym, false); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+
+	if len(loc.Line) != 1 || loc.Line[0].Function.Name != "main.main" {
+		t.Errorf("loc.Line = %+v, want one line for main.main", loc.Line)
+	}
+	if len(p.Function) != 1 {
+		t.Errorf("len(Function) = %d, want 1", len(p.Function))
+	}
+	if !m.HasFunctions || !m.HasFilenames || !m.HasLineNumbers {
+		t.Errorf("mapping flags = %+v, want all true", m)
+	}
+}
+
+// TestSymbolizePartialMappingNotFullyResolved guards against treating
+// a mapping as fully symbolized when only some of its locations
+// resolved: Has* must use AND semantics across the mapping.
+func TestSymbolizePartialMappingNotFullyResolved(t *testing.T) {
+	m := &Mapping{ID: 1, File: "a.out"}
+	resolved := &Location{ID: 1, Mapping: m, Address: 0x1000}
+	unresolved := &Location{ID: 2, Mapping: m, Address: 0x2000}
+	p := &Profile{Mapping: []*Mapping{m}, Location: []*Location{resolved, unresolved}}
+
+	sym := stubSymbolizer{0x1000: []Line{{Function: &Function{Name: "main.main", Filename: "main.go"}, Line: 10}}}
+	if err := p.Symbolize(sym, false); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+
+	if m.HasFunctions {
+		t.Error("HasFunctions = true, want false: one location in the mapping was never resolved")
+	}
+	if p.HasFunctions() {
+		t.Error("Profile.HasFunctions() = true, want false")
+	}
+}
+
+func TestSymbolizeDedupsFunctions(t *testing.T) {
+	m := &Mapping{ID: 1, File: "a.out"}
+	l1 := &Location{ID: 1, Mapping: m, Address: 0x1000}
+	l2 := &Location{ID: 2, Mapping: m, Address: 0x2000}
+	p := &Profile{Mapping: []*Mapping{m}, Location: []*Location{l1, l2}}
+
+	fn := func() []Line { return []Line{{Function: &Function{Name: "main.main", Filename: "main.go"}, Line: 1}} }
+	sym := stubSymbolizer{0x1000: fn(), 0x2000: fn()}
+	if err := p.Symbolize(sym, false); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+
+	if len(p.Function) != 1 {
+		t.Errorf("len(Function) = %d, want 1 (dedup failed)", len(p.Function))
+	}
+	if l1.Line[0].Function != l2.Line[0].Function {
+		t.Error("l1 and l2 resolved to distinct Function objects, want the same deduped one")
+	}
+}